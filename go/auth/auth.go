@@ -0,0 +1,400 @@
+// Package auth implements the bearer-token authentication shared by the MCP
+// transports. A Verifier checks the token carried by an incoming
+// Authorization header; Authenticator is the http.Handler middleware that
+// enforces it and attaches the resulting Identity to the request context so
+// a ToolHandler can make per-tool authorization decisions. Three Verifier
+// implementations are provided: a static token list, JWT verification
+// against a JWKS URL, and RFC 7662 token introspection.
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Identity is the authenticated caller attached to the request context.
+type Identity struct {
+	Subject string
+	Scopes  []string
+}
+
+// HasScope reports whether id was granted scope.
+func (id Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the Identity an Authenticator attached to
+// ctx, if any.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Verifier checks a bearer token and returns the Identity it authenticates.
+type Verifier interface {
+	Verify(ctx context.Context, token string) (Identity, error)
+}
+
+// Authenticator is HTTP middleware that requires a valid bearer token on
+// every request it wraps, attaching the resulting Identity to the request
+// context for Next (and, ultimately, ToolHandlers) to read.
+type Authenticator struct {
+	Verifier Verifier
+	Next     http.Handler
+}
+
+// NewAuthenticator wraps next, requiring a bearer token verified by v.
+func NewAuthenticator(v Verifier, next http.Handler) *Authenticator {
+	return &Authenticator{Verifier: v, Next: next}
+}
+
+func (a *Authenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		unauthorized(w, "missing bearer token")
+		return
+	}
+
+	id, err := a.Verifier.Verify(r.Context(), token)
+	if err != nil {
+		unauthorized(w, err.Error())
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), identityKey{}, id)
+	a.Next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(h[len(prefix):])
+	if token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// unauthorized reports a failed authentication per RFC 6750 section 3: a
+// 401 with a WWW-Authenticate challenge, plus a JSON body carrying reason in
+// the same {"error": ...} shape as the transports' own error responses.
+func unauthorized(w http.ResponseWriter, reason string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="mcp", error="invalid_token"`)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": reason})
+}
+
+// StaticTokenVerifier authenticates against a fixed token -> Identity
+// table: the simplest mode, for local development and tests.
+type StaticTokenVerifier map[string]Identity
+
+// Verify implements Verifier.
+func (v StaticTokenVerifier) Verify(_ context.Context, token string) (Identity, error) {
+	id, ok := v[token]
+	if !ok {
+		return Identity{}, fmt.Errorf("unknown bearer token")
+	}
+	return id, nil
+}
+
+// JWKSVerifier verifies RS256 JWT bearer tokens against a JWKS endpoint,
+// checking the standard "aud"/"iss"/"exp" claims. Keys are cached for
+// CacheTTL (10 minutes if unset) before being re-fetched.
+type JWKSVerifier struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	CacheTTL time.Duration
+
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// Verify implements Verifier.
+func (v *JWKSVerifier) Verify(ctx context.Context, token string) (Identity, error) {
+	parsed, err := jwt.Parse(token, v.keyFunc(ctx),
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(v.Issuer),
+		jwt.WithAudience(v.Audience),
+	)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid token: %w", err)
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return Identity{}, fmt.Errorf("invalid token claims")
+	}
+	sub, _ := claims["sub"].(string)
+	return Identity{Subject: sub, Scopes: scopesFromClaims(claims)}, nil
+}
+
+func scopesFromClaims(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	if raw, ok := claims["scp"].([]interface{}); ok {
+		scopes := make([]string, 0, len(raw))
+		for _, s := range raw {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
+
+func (v *JWKSVerifier) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(tok *jwt.Token) (interface{}, error) {
+		kid, _ := tok.Header["kid"].(string)
+		return v.key(ctx, kid)
+	}
+}
+
+func (v *JWKSVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	ttl := v.CacheTTL
+	if ttl == 0 {
+		ttl = 10 * time.Minute
+	}
+	stale := v.keys == nil || time.Since(v.fetchedAt) > ttl
+	v.mu.Unlock()
+
+	if stale {
+		if err := v.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no matching JWKS key for kid %q", kid)
+	}
+	return key, nil
+}
+
+type jwksDocument struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (v *JWKSVerifier) refresh(ctx context.Context) error {
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.JWKSURL, nil)
+	if err != nil {
+		return fmt.Errorf("build JWKS request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch JWKS: unexpected status %s", resp.Status)
+	}
+
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := rsaPublicKeyFromJWK(k.N, k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func rsaPublicKeyFromJWK(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("decode modulus: %w", err)
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("decode exponent: %w", err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nb),
+		E: int(new(big.Int).SetBytes(eb).Int64()),
+	}, nil
+}
+
+// IntrospectionVerifier authenticates by POSTing the bearer token to an
+// RFC 7662 token introspection endpoint.
+type IntrospectionVerifier struct {
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+
+	HTTPClient *http.Client
+}
+
+type introspectionResponse struct {
+	Active  bool   `json:"active"`
+	Subject string `json:"sub"`
+	Scope   string `json:"scope"`
+	Expiry  int64  `json:"exp"`
+}
+
+// Verify implements Verifier.
+func (v *IntrospectionVerifier) Verify(ctx context.Context, token string) (Identity, error) {
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, fmt.Errorf("build introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if v.ClientID != "" {
+		req.SetBasicAuth(v.ClientID, v.ClientSecret)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("introspect token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("introspect token: unexpected status %s", resp.Status)
+	}
+
+	var ir introspectionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ir); err != nil {
+		return Identity{}, fmt.Errorf("decode introspection response: %w", err)
+	}
+	if !ir.Active {
+		return Identity{}, fmt.Errorf("token is not active")
+	}
+	if ir.Expiry != 0 && time.Now().Unix() >= ir.Expiry {
+		return Identity{}, fmt.Errorf("token is expired")
+	}
+
+	return Identity{Subject: ir.Subject, Scopes: strings.Fields(ir.Scope)}, nil
+}
+
+// VerifierFromEnv builds a Verifier from environment variables, selecting
+// one of the three supported modes via MCP_AUTH_MODE:
+//
+//	MCP_AUTH_MODE=static (the default)
+//	  MCP_AUTH_STATIC_TOKENS="token1:subject1:scope-a scope-b,token2:subject2"
+//
+//	MCP_AUTH_MODE=jwks
+//	  MCP_AUTH_JWKS_URL, MCP_AUTH_ISSUER, MCP_AUTH_AUDIENCE
+//
+//	MCP_AUTH_MODE=introspection
+//	  MCP_AUTH_INTROSPECTION_URL, MCP_AUTH_CLIENT_ID, MCP_AUTH_CLIENT_SECRET
+//
+// With no MCP_AUTH_* variables set at all, static mode falls back to a
+// single built-in development token ("dev-token") so the example servers
+// keep working out of the box.
+func VerifierFromEnv() (Verifier, error) {
+	switch mode := os.Getenv("MCP_AUTH_MODE"); mode {
+	case "", "static":
+		return staticVerifierFromEnv(), nil
+
+	case "jwks":
+		jwksURL := os.Getenv("MCP_AUTH_JWKS_URL")
+		if jwksURL == "" {
+			return nil, fmt.Errorf("MCP_AUTH_MODE=jwks requires MCP_AUTH_JWKS_URL")
+		}
+		return &JWKSVerifier{
+			JWKSURL:  jwksURL,
+			Issuer:   os.Getenv("MCP_AUTH_ISSUER"),
+			Audience: os.Getenv("MCP_AUTH_AUDIENCE"),
+		}, nil
+
+	case "introspection":
+		introspectionURL := os.Getenv("MCP_AUTH_INTROSPECTION_URL")
+		if introspectionURL == "" {
+			return nil, fmt.Errorf("MCP_AUTH_MODE=introspection requires MCP_AUTH_INTROSPECTION_URL")
+		}
+		return &IntrospectionVerifier{
+			IntrospectionURL: introspectionURL,
+			ClientID:         os.Getenv("MCP_AUTH_CLIENT_ID"),
+			ClientSecret:     os.Getenv("MCP_AUTH_CLIENT_SECRET"),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown MCP_AUTH_MODE %q", mode)
+	}
+}
+
+func staticVerifierFromEnv() StaticTokenVerifier {
+	raw := os.Getenv("MCP_AUTH_STATIC_TOKENS")
+	if raw == "" {
+		return StaticTokenVerifier{"dev-token": {Subject: "dev"}}
+	}
+
+	verifier := StaticTokenVerifier{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		id := Identity{Subject: parts[0]}
+		token := parts[0]
+		if len(parts) > 1 {
+			id.Subject = parts[1]
+		}
+		if len(parts) > 2 {
+			id.Scopes = strings.Fields(parts[2])
+		}
+		verifier[token] = id
+	}
+	return verifier
+}
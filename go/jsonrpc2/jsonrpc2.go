@@ -0,0 +1,377 @@
+// Package jsonrpc2 implements the minimal bidirectional JSON-RPC 2.0 core
+// shared by the SSE and streamable MCP transports: request/response framing,
+// batch (array) bodies, outbound calls from server to client, and
+// cancellation of in-flight inbound requests. It is modelled after the
+// go.googlesource.com/tools internal/jsonrpc2 package, scaled down to what
+// the MCP example servers need.
+package jsonrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Well-known JSON-RPC 2.0 / MCP error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeCancelled      = -32800
+)
+
+// ID identifies a request. Exactly one of a name or a number is set; the
+// zero value is invalid and must not be used as a request ID.
+type ID struct {
+	name     string
+	number   int64
+	isString bool
+	isSet    bool
+}
+
+// NewNumberID builds a numeric request ID.
+func NewNumberID(n int64) ID { return ID{number: n, isSet: true} }
+
+// NewStringID builds a string request ID.
+func NewStringID(s string) ID { return ID{name: s, isString: true, isSet: true} }
+
+// IsValid reports whether id was produced by NewNumberID/NewStringID.
+func (id ID) IsValid() bool { return id.isSet }
+
+func (id ID) String() string {
+	if id.isString {
+		return id.name
+	}
+	return fmt.Sprintf("%d", id.number)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (id ID) MarshalJSON() ([]byte, error) {
+	if !id.isSet {
+		return []byte("null"), nil
+	}
+	if id.isString {
+		return json.Marshal(id.name)
+	}
+	return json.Marshal(id.number)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting either a JSON string
+// or a JSON number as the wire representation of an ID.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	*id = ID{}
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*id = ID{number: n, isSet: true}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid request id %q", data)
+	}
+	*id = ID{name: s, isString: true, isSet: true}
+	return nil
+}
+
+// Request is a single JSON-RPC 2.0 request or notification. A Request whose
+// ID is nil is a notification and expects no Response.
+type Request struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	ID     *ID             `json:"id,omitempty"`
+}
+
+// IsNotify reports whether req carries no ID and therefore expects no reply.
+func (req *Request) IsNotify() bool { return req.ID == nil }
+
+// MarshalJSON implements json.Marshaler, adding the mandatory "jsonrpc":"2.0"
+// member to the wire form.
+func (req *Request) MarshalJSON() ([]byte, error) {
+	type alias Request
+	return json.Marshal(&struct {
+		JSONRPC string `json:"jsonrpc"`
+		*alias
+	}{JSONRPC: "2.0", alias: (*alias)(req)})
+}
+
+// Response is a single JSON-RPC 2.0 response object.
+type Response struct {
+	ID     ID              `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, adding the mandatory "jsonrpc":"2.0"
+// member to the wire form.
+func (resp *Response) MarshalJSON() ([]byte, error) {
+	type alias Response
+	return json.Marshal(&struct {
+		JSONRPC string `json:"jsonrpc"`
+		*alias
+	}{JSONRPC: "2.0", alias: (*alias)(resp)})
+}
+
+// Error represents a JSON-RPC 2.0 error object, and also implements the
+// error interface so handlers can return it directly.
+type Error struct {
+	Code    int64           `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// wireMessage is shared wire shape used only to sniff whether an inbound
+// element is a request (has "method") or a response (has "result"/"error"),
+// without committing to either schema up front.
+type wireMessage struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	ID     *ID             `json:"id,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Handler dispatches a single inbound Request to produce a result value (or
+// an error, which is reported back as a JSON-RPC error object). The Conn is
+// passed through so a handler can issue requests back to the peer - e.g. a
+// "tools/call" implementation asking for "sampling/createMessage" - while
+// it is still being served.
+type Handler func(ctx context.Context, conn *Conn, req *Request) (interface{}, error)
+
+// Stream is the transport a Conn is layered over: SSE+POST and the
+// streamable /mcp endpoint each provide one. Write carries a complete
+// JSON-RPC payload (a single object or a batch array) out to the peer.
+type Stream interface {
+	Write(ctx context.Context, data []byte) error
+	Close() error
+}
+
+// MethodFunc handles one JSON-RPC method by name.
+type MethodFunc func(ctx context.Context, conn *Conn, req *Request) (interface{}, error)
+
+// Router dispatches by method name against a registered table of
+// MethodFuncs, replacing a hand-rolled switch statement. Router.Serve
+// satisfies the Handler signature so it can be passed directly to NewConn.
+type Router struct {
+	mu      sync.RWMutex
+	methods map[string]MethodFunc
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{methods: make(map[string]MethodFunc)}
+}
+
+// Handle registers fn to serve method.
+func (rt *Router) Handle(method string, fn MethodFunc) {
+	rt.mu.Lock()
+	rt.methods[method] = fn
+	rt.mu.Unlock()
+}
+
+// Serve looks up the method table and invokes the matching MethodFunc.
+func (rt *Router) Serve(ctx context.Context, conn *Conn, req *Request) (interface{}, error) {
+	rt.mu.RLock()
+	fn, ok := rt.methods[req.Method]
+	rt.mu.RUnlock()
+	if !ok {
+		return nil, &Error{Code: CodeMethodNotFound, Message: "Method not found"}
+	}
+	return fn(ctx, conn, req)
+}
+
+// Conn is a bidirectional JSON-RPC 2.0 connection: it dispatches inbound
+// requests to a Handler and can also issue outbound requests/notifications
+// to the peer over the same Stream.
+type Conn struct {
+	stream  Stream
+	handler Handler
+
+	seq int64 // atomically incremented to mint outbound request IDs
+
+	pendingMu sync.Mutex
+	pending   map[ID]chan *Response // outbound Calls awaiting a response
+
+	handlingMu sync.Mutex
+	handling   map[ID]context.CancelFunc // inbound requests that can be cancelled
+}
+
+// NewConn creates a Conn that writes outbound traffic to stream and
+// dispatches inbound requests to handler.
+func NewConn(stream Stream, handler Handler) *Conn {
+	return &Conn{
+		stream:   stream,
+		handler:  handler,
+		pending:  make(map[ID]chan *Response),
+		handling: make(map[ID]context.CancelFunc),
+	}
+}
+
+// Call issues an outbound request to the peer (e.g. "sampling/createMessage"
+// or "roots/list") and blocks for the matching response, until ctx is
+// cancelled. This only works over a transport whose Conn outlives a single
+// request/response exchange - the peer's reply has to land on the same
+// pending map that's waiting for it. SSE and WebSocket keep one Conn per
+// session, so Call works there; the streamable HTTP transport mints a new
+// Conn per POST and can't use it (see streamableHandler's doc comment).
+func (c *Conn) Call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	id := NewNumberID(atomic.AddInt64(&c.seq, 1))
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("marshal params: %w", err)
+	}
+
+	ch := make(chan *Response, 1)
+	c.pendingMu.Lock()
+	c.pending[id] = ch
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}()
+
+	data, err := json.Marshal(&Request{Method: method, Params: raw, ID: &id})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	if err := c.stream.Write(ctx, data); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Notify sends a one-way notification; no response is expected.
+func (c *Conn) Notify(ctx context.Context, method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("marshal params: %w", err)
+	}
+	data, err := json.Marshal(&Request{Method: method, Params: raw})
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+	return c.stream.Write(ctx, data)
+}
+
+// Deliver feeds one inbound HTTP body - a single JSON-RPC object or a batch
+// array - into the connection. Responses addressed to our own outstanding
+// Calls are routed to their waiting caller; "notifications/cancelled" is
+// routed to handleCancel; everything else is dispatched to the Handler. The
+// Response for each inbound request (if any) is returned so the caller can
+// decide how to deliver it - as the HTTP response body, or pushed down the
+// Stream - since that varies by transport.
+func (c *Conn) Deliver(ctx context.Context, body []byte) []*Response {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		batch = []json.RawMessage{body}
+	}
+
+	var responses []*Response
+	for _, raw := range batch {
+		var peek wireMessage
+		if err := json.Unmarshal(raw, &peek); err != nil {
+			responses = append(responses, &Response{Error: &Error{Code: CodeParseError, Message: "failed to parse body"}})
+			continue
+		}
+
+		switch {
+		case peek.Method == "" && (peek.Result != nil || peek.Error != nil):
+			c.handleResult(peek)
+
+		case peek.Method == "notifications/cancelled":
+			c.handleCancel(peek.Params)
+
+		case peek.ID == nil:
+			go c.handler(ctx, c, &Request{Method: peek.Method, Params: peek.Params})
+
+		default:
+			req := &Request{Method: peek.Method, Params: peek.Params, ID: peek.ID}
+			if resp := c.dispatch(ctx, req); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+	}
+	return responses
+}
+
+func (c *Conn) dispatch(ctx context.Context, req *Request) *Response {
+	id := *req.ID
+
+	hctx, cancel := context.WithCancel(ctx)
+	c.handlingMu.Lock()
+	c.handling[id] = cancel
+	c.handlingMu.Unlock()
+	defer func() {
+		c.handlingMu.Lock()
+		delete(c.handling, id)
+		c.handlingMu.Unlock()
+		cancel()
+	}()
+
+	result, err := c.handler(hctx, c, req)
+	if err != nil {
+		if rpcErr, ok := err.(*Error); ok {
+			return &Response{ID: id, Error: rpcErr}
+		}
+		return &Response{ID: id, Error: &Error{Code: CodeInternalError, Message: err.Error()}}
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return &Response{ID: id, Error: &Error{Code: CodeInternalError, Message: err.Error()}}
+	}
+	return &Response{ID: id, Result: raw}
+}
+
+func (c *Conn) handleResult(peek wireMessage) {
+	if peek.ID == nil {
+		return
+	}
+	c.pendingMu.Lock()
+	ch, ok := c.pending[*peek.ID]
+	c.pendingMu.Unlock()
+	if !ok {
+		return
+	}
+	ch <- &Response{ID: *peek.ID, Result: peek.Result, Error: peek.Error}
+}
+
+// handleCancel looks up the cancel func registered for params.RequestID (set up
+// by dispatch for every in-flight inbound request) and invokes it.
+func (c *Conn) handleCancel(params json.RawMessage) {
+	var p struct {
+		RequestID ID     `json:"requestId"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+
+	c.handlingMu.Lock()
+	cancel, ok := c.handling[p.RequestID]
+	c.handlingMu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// Close shuts down the underlying Stream.
+func (c *Conn) Close() error {
+	return c.stream.Close()
+}
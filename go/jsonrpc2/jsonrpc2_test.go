@@ -0,0 +1,212 @@
+package jsonrpc2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestID_JSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		id   ID
+	}{
+		{"number", NewNumberID(42)},
+		{"zero number", NewNumberID(0)},
+		{"negative number", NewNumberID(-7)},
+		{"string", NewStringID("request-123")},
+		{"empty string", NewStringID("")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.id)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+
+			var got ID
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal(%s): %v", data, err)
+			}
+			if got != tt.id {
+				t.Fatalf("round-tripped %s into %#v, want %#v", data, got, tt.id)
+			}
+			if !got.IsValid() {
+				t.Fatalf("round-tripped ID reports IsValid() == false")
+			}
+		})
+	}
+}
+
+func TestID_UnmarshalInvalid(t *testing.T) {
+	var id ID
+	if err := json.Unmarshal([]byte(`true`), &id); err == nil {
+		t.Fatalf("Unmarshal(true) into ID: want error, got nil")
+	}
+}
+
+func TestID_MarshalUnset(t *testing.T) {
+	var id ID
+	data, err := json.Marshal(id)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(data) != "null" {
+		t.Fatalf("Marshal(unset ID) = %s, want null", data)
+	}
+}
+
+// echoHandler replies to "echo" with its params verbatim and records every
+// notification it's dispatched on notified, so a test can observe the
+// goroutine Deliver spawns for them.
+func echoHandler(notified chan<- string) Handler {
+	return func(_ context.Context, _ *Conn, req *Request) (interface{}, error) {
+		if req.Method == "notify" {
+			notified <- string(req.Params)
+			return nil, nil
+		}
+		var v json.RawMessage
+		if err := json.Unmarshal(req.Params, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func TestConn_Deliver_SingleObject(t *testing.T) {
+	conn := NewConn(nil, echoHandler(make(chan string, 1)))
+
+	req := &Request{Method: "echo", Params: json.RawMessage(`"hi"`), ID: idPtr(NewNumberID(1))}
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+
+	responses := conn.Deliver(context.Background(), body)
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(responses))
+	}
+	if responses[0].ID != NewNumberID(1) {
+		t.Fatalf("response ID = %v, want 1", responses[0].ID)
+	}
+	if string(responses[0].Result) != `"hi"` {
+		t.Fatalf("response Result = %s, want %q", responses[0].Result, "hi")
+	}
+}
+
+func TestConn_Deliver_Batch(t *testing.T) {
+	notified := make(chan string, 1)
+	conn := NewConn(nil, echoHandler(notified))
+
+	batch := []*Request{
+		{Method: "echo", Params: json.RawMessage(`1`), ID: idPtr(NewNumberID(1))},
+		{Method: "notify", Params: json.RawMessage(`"side-effect"`)},
+		{Method: "echo", Params: json.RawMessage(`2`), ID: idPtr(NewNumberID(2))},
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatalf("marshal batch: %v", err)
+	}
+
+	responses := conn.Deliver(context.Background(), body)
+	if len(responses) != 2 {
+		t.Fatalf("got %d responses, want 2 (the notification shouldn't reply)", len(responses))
+	}
+	// Deliver dispatches requests with an ID synchronously in batch order,
+	// so the responses come back in the same order as the requests.
+	if string(responses[0].Result) != "1" || string(responses[1].Result) != "2" {
+		t.Fatalf("responses out of order: got %s, %s", responses[0].Result, responses[1].Result)
+	}
+
+	select {
+	case got := <-notified:
+		if got != `"side-effect"` {
+			t.Fatalf("notification params = %s, want %q", got, "side-effect")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("notification handler was never invoked")
+	}
+}
+
+// loopbackStream stands in for a transport in Call tests: it answers every
+// outbound request by feeding a matching Response straight back into the
+// same Conn's Deliver, the way a real peer's reply eventually would.
+type loopbackStream struct {
+	connMu sync.Mutex
+	conn   *Conn
+}
+
+func (s *loopbackStream) setConn(c *Conn) {
+	s.connMu.Lock()
+	s.conn = c
+	s.connMu.Unlock()
+}
+
+func (s *loopbackStream) Write(ctx context.Context, data []byte) error {
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	if req.ID == nil {
+		return nil
+	}
+
+	go func() {
+		resp := &Response{ID: *req.ID, Result: req.Params}
+		respData, err := json.Marshal(resp)
+		if err != nil {
+			return
+		}
+		s.connMu.Lock()
+		conn := s.conn
+		s.connMu.Unlock()
+		conn.Deliver(ctx, respData)
+	}()
+	return nil
+}
+
+func (s *loopbackStream) Close() error { return nil }
+
+// TestConn_Call_Concurrent exercises the pending map under -race: many
+// goroutines issue concurrent Calls on the same Conn, each must get back its
+// own response (not another caller's), and none may be left in the pending
+// map once every Call has returned.
+func TestConn_Call_Concurrent(t *testing.T) {
+	stream := &loopbackStream{}
+	conn := NewConn(stream, func(context.Context, *Conn, *Request) (interface{}, error) {
+		return nil, fmt.Errorf("unexpected inbound request")
+	})
+	stream.setConn(conn)
+
+	const n = 100
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			params := fmt.Sprintf("%d", i)
+			result, err := conn.Call(context.Background(), "echo", json.RawMessage(params))
+			if err != nil {
+				t.Errorf("Call(%d): %v", i, err)
+				return
+			}
+			if string(result) != params {
+				t.Errorf("Call(%d) result = %s, want %s", i, result, params)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	conn.pendingMu.Lock()
+	left := len(conn.pending)
+	conn.pendingMu.Unlock()
+	if left != 0 {
+		t.Fatalf("%d entries left in pending map after every Call returned", left)
+	}
+}
+
+func idPtr(id ID) *ID { return &id }
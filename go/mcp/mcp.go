@@ -0,0 +1,308 @@
+// Package mcp holds the MCP tool domain shared by every transport (SSE,
+// streamable HTTP, WebSocket): the wire types for tools and their results, a
+// pluggable ToolHandler so "tools/call" dispatch is never a hardcoded switch
+// over tool names, a ProgressReporter each handler can use to emit
+// "notifications/progress" without knowing which transport it is running
+// over, and JSON-Schema argument validation against a tool's InputSchema.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/CCpro10/mcp_examples/go/jsonrpc2"
+)
+
+// InitializeResult is returned by the "initialize" method.
+type InitializeResult struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	ServerInfo      map[string]string      `json:"serverInfo"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+}
+
+// ToolsListResult lists available tools.
+type ToolsListResult struct {
+	Tools []Tool `json:"tools"`
+}
+
+// ToolResult holds the payload for "tools/call".
+type ToolResult struct {
+	Content []ContentBlock `json:"content"`
+	IsError bool           `json:"isError"`
+}
+
+// ContentBlock represents the content section inside ToolResult.
+type ContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Tool describes a single tool entry.
+type Tool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	InputSchema InputSchema `json:"inputSchema"`
+}
+
+// InputSchema defines tool arguments as a (deliberately small) subset of
+// JSON Schema: an object with named, typed properties and a required list.
+type InputSchema struct {
+	Type       string                 `json:"type"`
+	Properties map[string]PropertyDef `json:"properties"`
+	Required   []string               `json:"required"`
+}
+
+// PropertyDef specifies a single input argument.
+type PropertyDef struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// ProgressReporter lets a ToolHandler emit "notifications/progress" without
+// knowing which transport - SSE, streamable HTTP, WebSocket - it is running
+// over.
+type ProgressReporter interface {
+	Report(ctx context.Context, progress, total int, message string) error
+}
+
+type noopProgress struct{}
+
+func (noopProgress) Report(context.Context, int, int, string) error { return nil }
+
+// NoopProgress is a ProgressReporter that discards every report, for tools
+// that don't report progress.
+var NoopProgress ProgressReporter = noopProgress{}
+
+// ToolHandler is the pluggable per-tool implementation a ToolRegistry
+// dispatches "tools/call" to, in place of a hardcoded switch over tool
+// names.
+type ToolHandler interface {
+	Call(ctx context.Context, args json.RawMessage, progress ProgressReporter) (ToolResult, error)
+}
+
+// ToolHandlerFunc adapts a plain function to a ToolHandler, the way
+// http.HandlerFunc adapts a function to an http.Handler.
+type ToolHandlerFunc func(ctx context.Context, args json.RawMessage, progress ProgressReporter) (ToolResult, error)
+
+// Call implements ToolHandler.
+func (f ToolHandlerFunc) Call(ctx context.Context, args json.RawMessage, progress ProgressReporter) (ToolResult, error) {
+	return f(ctx, args, progress)
+}
+
+// FieldError describes one argument that failed schema validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by ToolRegistry.Call when arguments fail
+// schema validation; a transport maps it to JSON-RPC -32602 with Fields as
+// the structured error data.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid arguments: %d field(s) failed validation", len(e.Fields))
+}
+
+// UnknownToolError is returned by ToolRegistry.Call when name has no
+// registered handler.
+type UnknownToolError struct {
+	Name string
+}
+
+func (e *UnknownToolError) Error() string {
+	return fmt.Sprintf("unsupported tool name %q", e.Name)
+}
+
+// ToJSONRPCError maps a ToolRegistry.Call error to the JSON-RPC error
+// reported to the client: schema validation failures carry their failing
+// fields as structured error data. Every transport calls this from its
+// "tools/call" handler instead of hand-rolling the same mapping.
+func ToJSONRPCError(err error) *jsonrpc2.Error {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		data, _ := json.Marshal(struct {
+			Fields []FieldError `json:"fields"`
+		}{Fields: verr.Fields})
+		return &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: "invalid arguments", Data: data}
+	}
+	return &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: err.Error()}
+}
+
+// ValidateArguments checks rawArgs against schema: every required property
+// must be present, and every present declared property must match its JSON
+// type. This is intentionally a small, direct subset of JSON Schema - the
+// tools in this repo only ever declare "object"/"string"/"number"/"integer"/
+// "boolean" properties - rather than pulling in a general-purpose validator.
+func ValidateArguments(schema InputSchema, rawArgs json.RawMessage) *ValidationError {
+	var args map[string]interface{}
+	if len(rawArgs) > 0 {
+		if err := json.Unmarshal(rawArgs, &args); err != nil {
+			return &ValidationError{Fields: []FieldError{{Message: "arguments must be a JSON object"}}}
+		}
+	}
+
+	var fields []FieldError
+	for _, name := range schema.Required {
+		if _, ok := args[name]; !ok {
+			fields = append(fields, FieldError{Field: name, Message: "required property is missing"})
+		}
+	}
+	for name, value := range args {
+		prop, ok := schema.Properties[name]
+		if !ok || prop.Type == "" {
+			continue
+		}
+		if !matchesJSONType(value, prop.Type) {
+			fields = append(fields, FieldError{Field: name, Message: fmt.Sprintf("must be of type %q", prop.Type)})
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: fields}
+}
+
+func matchesJSONType(value interface{}, jsonType string) bool {
+	switch jsonType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	default:
+		return true
+	}
+}
+
+// ToolRegistry tracks the live set of tools a server advertises and calls
+// back whenever that set is mutated, so a transport can push
+// "notifications/tools/list_changed" to connected clients. Call validates
+// arguments against the target tool's InputSchema before dispatch.
+type ToolRegistry struct {
+	mu       sync.RWMutex
+	tools    map[string]Tool
+	order    []string
+	handlers map[string]ToolHandler
+	onChange func()
+}
+
+// NewToolRegistry returns an empty registry that calls onChange after every
+// Register and Replace, and after every Unregister that removes a tool.
+func NewToolRegistry(onChange func()) *ToolRegistry {
+	return &ToolRegistry{
+		tools:    make(map[string]Tool),
+		handlers: make(map[string]ToolHandler),
+		onChange: onChange,
+	}
+}
+
+// Register adds or replaces a single tool and notifies listeners.
+func (r *ToolRegistry) Register(tool Tool, handler ToolHandler) {
+	r.mu.Lock()
+	if _, exists := r.tools[tool.Name]; !exists {
+		r.order = append(r.order, tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	r.handlers[tool.Name] = handler
+	r.mu.Unlock()
+
+	r.notify()
+}
+
+// Unregister removes a tool by name and notifies listeners. An unknown name
+// is a no-op and notifies nobody.
+func (r *ToolRegistry) Unregister(name string) {
+	r.mu.Lock()
+	if _, exists := r.tools[name]; !exists {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.tools, name)
+	delete(r.handlers, name)
+	for i, n := range r.order {
+		if n == name {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	r.notify()
+}
+
+// Replace swaps the entire tool set atomically and notifies listeners.
+func (r *ToolRegistry) Replace(tools []Tool, handlers map[string]ToolHandler) {
+	r.mu.Lock()
+	r.tools = make(map[string]Tool, len(tools))
+	r.order = make([]string, 0, len(tools))
+	r.handlers = make(map[string]ToolHandler, len(handlers))
+	for _, tool := range tools {
+		r.tools[tool.Name] = tool
+		r.order = append(r.order, tool.Name)
+	}
+	for name, handler := range handlers {
+		r.handlers[name] = handler
+	}
+	r.mu.Unlock()
+
+	r.notify()
+}
+
+// List returns the currently registered tools in registration order.
+func (r *ToolRegistry) List() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tools := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tools = append(tools, r.tools[name])
+	}
+	return tools
+}
+
+// Call validates rawArgs against the named tool's InputSchema and, if valid,
+// dispatches to its handler. progress may be nil, in which case the handler
+// gets NoopProgress.
+func (r *ToolRegistry) Call(ctx context.Context, name string, rawArgs json.RawMessage, progress ProgressReporter) (ToolResult, error) {
+	r.mu.RLock()
+	tool, okTool := r.tools[name]
+	handler, okHandler := r.handlers[name]
+	r.mu.RUnlock()
+
+	if !okTool || !okHandler {
+		return ToolResult{}, &UnknownToolError{Name: name}
+	}
+	if verr := ValidateArguments(tool.InputSchema, rawArgs); verr != nil {
+		return ToolResult{}, verr
+	}
+
+	if progress == nil {
+		progress = NoopProgress
+	}
+	return handler.Call(ctx, rawArgs, progress)
+}
+
+func (r *ToolRegistry) notify() {
+	if r.onChange != nil {
+		r.onChange()
+	}
+}
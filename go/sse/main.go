@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -8,112 +9,343 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CCpro10/mcp_examples/go/auth"
+	"github.com/CCpro10/mcp_examples/go/jsonrpc2"
+	"github.com/CCpro10/mcp_examples/go/mcp"
 )
 
 const (
 	pathSSE     = "/sse"
 	pathMessage = "/message"
 
+	// pathAdminTools and pathAdminRestoreTools let an operator mutate the
+	// live tool set - DELETE pathAdminTools+"<name>" to unregister one,
+	// POST pathAdminRestoreTools to replace it back to the default set -
+	// demonstrating that ToolRegistry.Unregister/Replace actually push
+	// "notifications/tools/list_changed" to connected sessions, not just at
+	// startup.
+	pathAdminTools        = "/admin/tools/"
+	pathAdminRestoreTools = pathAdminTools + "restore"
+
 	eventEndpoint = "endpoint"
 	eventMessage  = "message"
+
+	// defaultSessionGracePeriod is how long a session survives after its SSE
+	// connection drops, so a reconnecting client (or a slow in-flight
+	// tools/call) can still catch up instead of losing the session outright.
+	defaultSessionGracePeriod = 30 * time.Second
+
+	// defaultEventBufferSize bounds how many past events a session retains
+	// for Last-Event-ID replay.
+	defaultEventBufferSize = 256
+
+	// defaultMaxSessions bounds how many sessions - live or within their
+	// grace period - h.sessions may hold at once, so a client that opens
+	// many connections and disconnects before gracePeriod (or simply leaks
+	// sessionid values) can't grow the map and its per-session event
+	// buffers without limit.
+	defaultMaxSessions = 10000
 )
 
-// JSONRPCRequest models a JSON-RPC request payload.
-type JSONRPCRequest struct {
-	JSONRPC string                 `json:"jsonrpc"`
-	Method  string                 `json:"method"`
-	Params  map[string]interface{} `json:"params,omitempty"`
-	ID      interface{}            `json:"id,omitempty"`
+var toUppercaseTool = mcp.Tool{
+	Name:        "to-uppercase",
+	Description: "Converts the input string to uppercase.",
+	InputSchema: mcp.InputSchema{
+		Type: "object",
+		Properties: map[string]mcp.PropertyDef{
+			"input": {
+				Type:        "string",
+				Description: "The string to be converted to uppercase.",
+			},
+		},
+		Required: []string{"input"},
+	},
+}
+
+// reverseStringTool exists to give the /admin/tools endpoints something to
+// remove and restore: it demonstrates the registry actually being mutated
+// while clients are connected, rather than only at startup.
+var reverseStringTool = mcp.Tool{
+	Name:        "reverse-string",
+	Description: "Reverses the input string.",
+	InputSchema: mcp.InputSchema{
+		Type: "object",
+		Properties: map[string]mcp.PropertyDef{
+			"input": {
+				Type:        "string",
+				Description: "The string to be reversed.",
+			},
+		},
+		Required: []string{"input"},
+	},
 }
 
-// JSONRPCResponse models a JSON-RPC response payload.
-type JSONRPCResponse struct {
-	JSONRPC string        `json:"jsonrpc"`
-	ID      interface{}   `json:"id,omitempty"`
-	Result  interface{}   `json:"result,omitempty"`
-	Error   *JSONRPCError `json:"error,omitempty"`
+// listClientRootsTool demonstrates a server-initiated request made while a
+// tools/call is in flight (jsonrpc2.Conn.Call): the SSE session's Conn lives
+// for as long as the client stays connected, so the client's eventual
+// "roots/list" reply lands in the same pending map this call is waiting on.
+var listClientRootsTool = mcp.Tool{
+	Name:        "list-client-roots",
+	Description: "Asks the connected client for its roots/list while this call is in flight.",
+	InputSchema: mcp.InputSchema{
+		Type: "object",
+	},
 }
 
-// JSONRPCError represents a JSON-RPC error object.
-type JSONRPCError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+// connCtxKey carries the session's jsonrpc2.Conn through a tools/call's ctx,
+// so a ToolHandler can issue its own server-to-client requests.
+type connCtxKey struct{}
+
+func connFromContext(ctx context.Context) *jsonrpc2.Conn {
+	conn, _ := ctx.Value(connCtxKey{}).(*jsonrpc2.Conn)
+	return conn
 }
 
-// InitializeResult is returned by the "initialize" method.
-type InitializeResult struct {
-	ProtocolVersion string                 `json:"protocolVersion"`
-	ServerInfo      map[string]string      `json:"serverInfo"`
-	Capabilities    map[string]interface{} `json:"capabilities"`
+// bufferedEvent is one SSE frame retained so a reconnecting client can
+// replay what it missed via Last-Event-ID.
+type bufferedEvent struct {
+	id    int64
+	event string
+	data  string
 }
 
-// ToolsListResult lists available tools.
-type ToolsListResult struct {
-	Tools []Tool `json:"tools"`
+// session retains an SSE session across possibly multiple GET /sse
+// connections: a live writer/flusher while connected, the JSON-RPC Conn
+// layered over it, and a bounded ring buffer of every event sent so far.
+// A session outlives a single TCP connection for gracePeriod, so a POST to
+// /message can still be accepted - and its response buffered - while the
+// client is reconnecting.
+type session struct {
+	gracePeriod time.Duration
+	bufferCap   int
+
+	mu         sync.Mutex
+	response   http.ResponseWriter
+	flusher    http.Flusher
+	connected  bool
+	graceTimer *time.Timer
+
+	conn        *jsonrpc2.Conn
+	nextEventID int64
+	buffer      []bufferedEvent
 }
 
-// ToolResult holds the payload for "tools/call".
-type ToolResult struct {
-	Content []ContentBlock `json:"content"`
-	IsError bool           `json:"isError"`
+func newSession(bufferCap int, gracePeriod time.Duration) *session {
+	return &session{bufferCap: bufferCap, gracePeriod: gracePeriod}
 }
 
-// ContentBlock represents the content section inside ToolResult.
-type ContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+// appendEvent stamps the next event ID, retains the event in the ring
+// buffer, and - if a client is currently connected - flushes it live.
+func (s *session) appendEvent(name, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextEventID++
+	ev := bufferedEvent{id: s.nextEventID, event: name, data: data}
+	s.buffer = append(s.buffer, ev)
+	if len(s.buffer) > s.bufferCap {
+		s.buffer = s.buffer[len(s.buffer)-s.bufferCap:]
+	}
+
+	if s.connected {
+		writeSSEEvent(s.response, s.flusher, ev)
+	}
 }
 
-// Tool describes a single tool entry.
-type Tool struct {
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	InputSchema InputSchema `json:"inputSchema"`
+// attach binds a freshly-accepted GET /sse connection to the session,
+// replaying any buffered events newer than lastEventID before the caller
+// resumes live streaming.
+func (s *session) attach(w http.ResponseWriter, flusher http.Flusher, lastEventID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	s.response = w
+	s.flusher = flusher
+	s.connected = true
+
+	for _, ev := range s.buffer {
+		if ev.id > lastEventID {
+			writeSSEEvent(w, flusher, ev)
+		}
+	}
 }
 
-// InputSchema defines tool arguments.
-type InputSchema struct {
-	Type       string                 `json:"type"`
-	Properties map[string]PropertyDef `json:"properties"`
-	Required   []string               `json:"required"`
+// detach marks the session disconnected and arms a grace-period timer that
+// invokes onExpire if nobody reconnects in time. The timer fires on its own
+// goroutine, so by the time it runs a reconnect may already have called
+// attach() and rebound the session to a new stream; re-check s.connected
+// under s.mu before expiring so that race can't delete a now-live session.
+func (s *session) detach(onExpire func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connected = false
+	s.response = nil
+	s.flusher = nil
+	s.graceTimer = time.AfterFunc(s.gracePeriod, func() {
+		s.mu.Lock()
+		stillGone := !s.connected
+		s.mu.Unlock()
+		if stillGone {
+			onExpire()
+		}
+	})
 }
 
-// PropertyDef specifies a single input argument.
-type PropertyDef struct {
-	Type        string `json:"type"`
-	Description string `json:"description"`
+func writeSSEEvent(w http.ResponseWriter, flusher http.Flusher, ev bufferedEvent) {
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.id, ev.event, ev.data); err != nil {
+		log.Printf("send event failed: %v", err)
+		return
+	}
+	flusher.Flush()
 }
 
-var toUppercaseTool = Tool{
-	Name:        "to-uppercase",
-	Description: "Converts the input string to uppercase.",
-	InputSchema: InputSchema{
-		Type: "object",
-		Properties: map[string]PropertyDef{
-			"input": {
-				Type:        "string",
-				Description: "The string to be converted to uppercase.",
-			},
-		},
-		Required: []string{"input"},
-	},
+// sseStream adapts a session's SSE writer to jsonrpc2.Stream, so a Conn can
+// push notifications and server-to-client requests down the open stream.
+type sseStream struct {
+	s *session
 }
 
-// session retains an active SSE connection.
-type session struct {
-	response http.ResponseWriter
-	flusher  http.Flusher
+func (st *sseStream) Write(_ context.Context, data []byte) error {
+	st.s.appendEvent(eventMessage, string(data))
+	return nil
 }
 
+func (st *sseStream) Close() error { return nil }
+
 // sseHandler manages lifecycle of SSE sessions.
 type sseHandler struct {
-	sessions sync.Map // map[string]*session
+	sessions     sync.Map // map[string]*session
+	sessionCount int64    // atomically maintained len(sessions), since sync.Map has no O(1) count
+	registry     *mcp.ToolRegistry
+	router       *jsonrpc2.Router
+	gracePeriod  time.Duration
+	bufferSize   int
+	maxSessions  int
 }
 
 func newSSEHandler() *sseHandler {
-	return &sseHandler{}
+	return newSSEHandlerWithConfig(defaultSessionGracePeriod, defaultEventBufferSize, defaultMaxSessions)
+}
+
+func newSSEHandlerWithConfig(gracePeriod time.Duration, bufferSize, maxSessions int) *sseHandler {
+	h := &sseHandler{gracePeriod: gracePeriod, bufferSize: bufferSize, maxSessions: maxSessions}
+	h.registry = mcp.NewToolRegistry(h.broadcastToolsChanged)
+	tools, handlers := defaultTools()
+	h.registry.Replace(tools, handlers)
+
+	h.router = jsonrpc2.NewRouter()
+	h.router.Handle("initialize", h.handleInitialize)
+	h.router.Handle("tools/list", h.handleToolsList)
+	h.router.Handle("tools/call", h.handleToolCall)
+	return h
+}
+
+func (h *sseHandler) handleInitialize(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (interface{}, error) {
+	return mcp.InitializeResult{
+		ProtocolVersion: "2025-03-26",
+		ServerInfo: map[string]string{
+			"name":    "Go To-Uppercase Server",
+			"version": "12.0.0",
+		},
+		Capabilities: map[string]interface{}{
+			"tools": map[string]interface{}{"listChanged": true},
+		},
+	}, nil
+}
+
+func (h *sseHandler) handleToolsList(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (interface{}, error) {
+	return mcp.ToolsListResult{Tools: h.registry.List()}, nil
+}
+
+func (h *sseHandler) handleToolCall(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: "invalid params"}
+	}
+
+	ctx = context.WithValue(ctx, connCtxKey{}, conn)
+	result, err := h.registry.Call(ctx, params.Name, params.Arguments, nil)
+	if err != nil {
+		return nil, mcp.ToJSONRPCError(err)
+	}
+	return result, nil
+}
+
+// defaultTools builds the full tool set the server starts with, shared
+// between the constructor's initial Replace and the /admin/tools/restore
+// endpoint's demonstration of restoring it later.
+func defaultTools() ([]mcp.Tool, map[string]mcp.ToolHandler) {
+	tools := []mcp.Tool{toUppercaseTool, reverseStringTool, listClientRootsTool}
+	handlers := map[string]mcp.ToolHandler{
+		toUppercaseTool.Name: mcp.ToolHandlerFunc(func(_ context.Context, args json.RawMessage, _ mcp.ProgressReporter) (mcp.ToolResult, error) {
+			var in struct {
+				Input string `json:"input"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return mcp.ToolResult{}, err
+			}
+			upper, err := performToUppercase(in.Input)
+			if err != nil {
+				return mcp.ToolResult{}, err
+			}
+			return mcp.ToolResult{Content: []mcp.ContentBlock{{Type: "text", Text: upper}}}, nil
+		}),
+		reverseStringTool.Name: mcp.ToolHandlerFunc(func(_ context.Context, args json.RawMessage, _ mcp.ProgressReporter) (mcp.ToolResult, error) {
+			var in struct {
+				Input string `json:"input"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return mcp.ToolResult{}, err
+			}
+			return mcp.ToolResult{Content: []mcp.ContentBlock{{Type: "text", Text: reverseString(in.Input)}}}, nil
+		}),
+		listClientRootsTool.Name: mcp.ToolHandlerFunc(func(ctx context.Context, _ json.RawMessage, _ mcp.ProgressReporter) (mcp.ToolResult, error) {
+			conn := connFromContext(ctx)
+			if conn == nil {
+				return mcp.ToolResult{}, fmt.Errorf("no connection available to call the client")
+			}
+			result, err := conn.Call(ctx, "roots/list", nil)
+			if err != nil {
+				return mcp.ToolResult{}, fmt.Errorf("roots/list call failed: %w", err)
+			}
+			return mcp.ToolResult{Content: []mcp.ContentBlock{{Type: "text", Text: string(result)}}}, nil
+		}),
+	}
+	return tools, handlers
+}
+
+func reverseString(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// broadcastToolsChanged pushes a "notifications/tools/list_changed" message
+// to every active SSE session.
+func (h *sseHandler) broadcastToolsChanged() {
+	h.sessions.Range(func(_, value interface{}) bool {
+		s := value.(*session)
+		if err := s.conn.Notify(context.Background(), "notifications/tools/list_changed", nil); err != nil {
+			log.Printf("notify tools/list_changed failed: %v", err)
+		}
+		return true
+	})
 }
 
 func (h *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -122,6 +354,10 @@ func (h *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleStream(w, r)
 	case r.Method == http.MethodPost && r.URL.Path == pathMessage:
 		h.handleMessage(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == pathAdminRestoreTools:
+		h.handleAdminRestoreTools(w, r)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, pathAdminTools):
+		h.handleAdminRemoveTool(w, r)
 	default:
 		jsonError(w, "Method or Path not allowed", http.StatusMethodNotAllowed)
 	}
@@ -134,18 +370,46 @@ func (h *sseHandler) handleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sid := generateSessionID()
-	h.sessions.Store(sid, &session{response: w, flusher: flusher})
-	defer h.sessions.Delete(sid)
+	sid := r.URL.Query().Get("sessionid")
+	lastEventID := parseLastEventID(r.Header.Get("Last-Event-ID"))
+
+	var s *session
+	if sid != "" {
+		if value, ok := h.sessions.Load(sid); ok {
+			s = value.(*session)
+		}
+	}
+
+	isNew := s == nil
+	if isNew {
+		if atomic.AddInt64(&h.sessionCount, 1) > int64(h.maxSessions) {
+			atomic.AddInt64(&h.sessionCount, -1)
+			jsonError(w, "too many concurrent sessions", http.StatusServiceUnavailable)
+			return
+		}
+		sid = generateSessionID()
+		s = newSession(h.bufferSize, h.gracePeriod)
+		s.conn = jsonrpc2.NewConn(&sseStream{s: s}, h.router.Serve)
+		h.sessions.Store(sid, s)
+	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	endpointURL := fmt.Sprintf("%s://%s%s?sessionid=%s", schemeFromRequest(r), r.Host, pathMessage, sid)
-	sendEvent(w, flusher, eventEndpoint, endpointURL)
+	s.attach(w, flusher, lastEventID)
+
+	if isNew {
+		endpointURL := fmt.Sprintf("%s://%s%s?sessionid=%s", schemeFromRequest(r), r.Host, pathMessage, sid)
+		s.appendEvent(eventEndpoint, endpointURL)
+	}
 
 	<-r.Context().Done()
+
+	s.detach(func() {
+		h.sessions.Delete(sid)
+		atomic.AddInt64(&h.sessionCount, -1)
+	})
 }
 
 func (h *sseHandler) handleMessage(w http.ResponseWriter, r *http.Request) {
@@ -167,92 +431,54 @@ func (h *sseHandler) handleMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req JSONRPCRequest
-	if err := json.Unmarshal(payload, &req); err != nil {
-		jsonError(w, "failed to parse body", http.StatusBadRequest)
-		return
-	}
-
-	go h.respond(value.(*session), sid, req)
+	go h.respond(value.(*session), payload)
 	w.WriteHeader(http.StatusAccepted)
 }
 
-func (h *sseHandler) respond(s *session, sid string, req JSONRPCRequest) {
-	resp := buildResponse(req)
-	data, err := json.Marshal(resp)
-	if err != nil {
-		log.Printf("marshal response failed: %v", err)
-		return
-	}
-
-	current, ok := h.sessions.Load(sid)
-	if !ok {
-		log.Printf("session %s closed before response was sent", sid)
+// handleAdminRemoveTool unregisters the tool named by the path suffix after
+// pathAdminTools, proving ToolRegistry.Unregister reaches a connected
+// session: every SSE stream currently open gets a live
+// "notifications/tools/list_changed" as a side effect.
+func (h *sseHandler) handleAdminRemoveTool(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, pathAdminTools)
+	if name == "" {
+		jsonError(w, "tool name must be provided", http.StatusBadRequest)
 		return
 	}
-
-	sendEvent(current.(*session).response, current.(*session).flusher, eventMessage, string(data))
+	h.registry.Unregister(name)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func buildResponse(req JSONRPCRequest) *JSONRPCResponse {
-	switch req.Method {
-	case "initialize":
-		result := InitializeResult{
-			ProtocolVersion: "2025-03-26",
-			ServerInfo: map[string]string{
-				"name":    "Go To-Uppercase Server",
-				"version": "12.0.0",
-			},
-			Capabilities: map[string]interface{}{
-				"tools": map[string]interface{}{"listChanged": true},
-			},
-		}
-		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+// handleAdminRestoreTools replaces the live tool set back to the default
+// via ToolRegistry.Replace, the counterpart demo to handleAdminRemoveTool.
+func (h *sseHandler) handleAdminRestoreTools(w http.ResponseWriter, _ *http.Request) {
+	tools, handlers := defaultTools()
+	h.registry.Replace(tools, handlers)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	case "tools/list":
-		return &JSONRPCResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result:  ToolsListResult{Tools: []Tool{toUppercaseTool}},
-		}
+func (h *sseHandler) respond(s *session, payload []byte) {
+	responses := s.conn.Deliver(context.Background(), payload)
 
-	case "tools/call":
-		if req.Params == nil || req.Params["name"] != "to-uppercase" {
-			return newErrorResponse(req.ID, -32602, "Unsupported tool name")
-		}
-		args, _ := req.Params["arguments"].(map[string]interface{})
-		input, _ := args["input"].(string)
-		upper, err := performToUppercase(input)
+	for _, resp := range responses {
+		data, err := json.Marshal(resp)
 		if err != nil {
-			return newErrorResponse(req.ID, -32602, err.Error())
+			log.Printf("marshal response failed: %v", err)
+			continue
 		}
-		result := ToolResult{
-			Content: []ContentBlock{{Type: "text", Text: upper}},
-			IsError: false,
-		}
-		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+		s.appendEvent(eventMessage, string(data))
 	}
-
-	return newErrorResponse(req.ID, -32601, "Method not found")
 }
 
-func newErrorResponse(id interface{}, code int, message string) *JSONRPCResponse {
-	return &JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Error: &JSONRPCError{
-			Code:    code,
-			Message: message,
-		},
+func parseLastEventID(header string) int64 {
+	if header == "" {
+		return 0
 	}
-}
-
-func sendEvent(w http.ResponseWriter, flusher http.Flusher, name, data string) {
-	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
-		log.Printf("send event failed: %v", err)
-		return
+	id, err := strconv.ParseInt(strings.TrimSpace(header), 10, 64)
+	if err != nil {
+		return 0
 	}
-	flusher.Flush()
+	return id
 }
 
 func performToUppercase(input string) (string, error) {
@@ -290,7 +516,12 @@ func schemeFromRequest(r *http.Request) string {
 }
 
 func main() {
-	http.Handle("/", newSSEHandler())
+	verifier, err := auth.VerifierFromEnv()
+	if err != nil {
+		log.Fatalf("configure auth: %v", err)
+	}
+
+	http.Handle("/", auth.NewAuthenticator(verifier, newSSEHandler()))
 	log.Println("SSE MCP server listening on http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
@@ -3,93 +3,46 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/CCpro10/mcp_examples/go/auth"
+	"github.com/CCpro10/mcp_examples/go/jsonrpc2"
+	"github.com/CCpro10/mcp_examples/go/mcp"
 )
 
+// errCancelled is returned by a tool handler when its ctx is done because
+// the client sent "notifications/cancelled" for this request.
+var errCancelled = errors.New("request cancelled")
+
 const (
 	streamablePath = "/mcp"
 
 	eventProgress = "message"
-)
-
-// JSONRPCRequest models a JSON-RPC request payload.
-type JSONRPCRequest struct {
-	JSONRPC string                 `json:"jsonrpc"`
-	Method  string                 `json:"method"`
-	Params  map[string]interface{} `json:"params,omitempty"`
-	ID      interface{}            `json:"id,omitempty"`
-}
-
-// JSONRPCResponse models a JSON-RPC response payload.
-type JSONRPCResponse struct {
-	JSONRPC string        `json:"jsonrpc"`
-	ID      interface{}   `json:"id,omitempty"`
-	Result  interface{}   `json:"result,omitempty"`
-	Error   *JSONRPCError `json:"error,omitempty"`
-}
-
-// JSONRPCError represents a JSON-RPC error object.
-type JSONRPCError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-}
-
-// InitializeResult is returned by the "initialize" method.
-type InitializeResult struct {
-	ProtocolVersion string                 `json:"protocolVersion"`
-	ServerInfo      map[string]string      `json:"serverInfo"`
-	Capabilities    map[string]interface{} `json:"capabilities"`
-}
-
-// ToolsListResult lists available tools.
-type ToolsListResult struct {
-	Tools []Tool `json:"tools"`
-}
-
-// ToolResult holds the payload for "tools/call".
-type ToolResult struct {
-	Content []ContentBlock `json:"content"`
-	IsError bool           `json:"isError"`
-}
 
-// ContentBlock represents the content section inside ToolResult.
-type ContentBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
-}
-
-// Tool describes a single tool entry.
-type Tool struct {
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	InputSchema InputSchema `json:"inputSchema"`
-}
-
-// InputSchema defines tool arguments.
-type InputSchema struct {
-	Type       string                 `json:"type"`
-	Properties map[string]PropertyDef `json:"properties"`
-	Required   []string               `json:"required"`
-}
-
-// PropertyDef specifies a single input argument.
-type PropertyDef struct {
-	Type        string `json:"type"`
-	Description string `json:"description"`
-}
+	// pathAdminTools and pathAdminRestoreTools let an operator mutate the
+	// live tool set - DELETE pathAdminTools+"<name>" to unregister one,
+	// POST pathAdminRestoreTools to replace it back to the default set -
+	// demonstrating that ToolRegistry.Unregister/Replace actually push
+	// "notifications/tools/list_changed" to connected clients, not just at
+	// startup.
+	pathAdminTools        = "/admin/tools/"
+	pathAdminRestoreTools = pathAdminTools + "restore"
+)
 
 var (
-	toUppercaseTool = Tool{
+	toUppercaseTool = mcp.Tool{
 		Name:        "to-uppercase",
 		Description: "Converts the input string to uppercase.",
-		InputSchema: InputSchema{
+		InputSchema: mcp.InputSchema{
 			Type: "object",
-			Properties: map[string]PropertyDef{
+			Properties: map[string]mcp.PropertyDef{
 				"input": {
 					Type:        "string",
 					Description: "The string to be converted to uppercase.",
@@ -99,12 +52,12 @@ var (
 		},
 	}
 
-	toUppercaseSlowlyTool = Tool{
+	toUppercaseSlowlyTool = mcp.Tool{
 		Name:        "to-uppercase-slowly",
 		Description: "Converts the input string to uppercase. (simulates slow processing)",
-		InputSchema: InputSchema{
+		InputSchema: mcp.InputSchema{
 			Type: "object",
-			Properties: map[string]PropertyDef{
+			Properties: map[string]mcp.PropertyDef{
 				"input": {
 					Type:        "string",
 					Description: "The string to be converted to uppercase.",
@@ -115,151 +68,358 @@ var (
 	}
 )
 
-// streamContext stores request-scoped data for SSE responses.
+// streamContext stores request-scoped data for SSE responses: the
+// underlying writer/flusher, the JSON-RPC Conn layered over them, and
+// whether the HTTP response has been upgraded to an event stream yet.
 type streamContext struct {
 	writer        http.ResponseWriter
 	flusher       http.Flusher
-	request       *JSONRPCRequest
+	conn          *jsonrpc2.Conn
 	upgradedToSSE bool
 }
 
 type ctxKey struct{}
 
-type streamableHandler struct{}
+// streamProgressReporter reports "notifications/progress" down the
+// streamable response's stream, carrying the client-supplied progressToken
+// so a ToolHandler never has to know about SSE-upgrade plumbing.
+type streamProgressReporter struct {
+	sc    *streamContext
+	token interface{}
+}
 
-func (h *streamableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost || r.URL.Path != streamablePath {
-		jsonError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
+func (p *streamProgressReporter) Report(ctx context.Context, progress, total int, message string) error {
+	payload := map[string]interface{}{
+		"progress":      progress,
+		"total":         total,
+		"progressToken": p.token,
+		"message":       message,
 	}
+	return p.sc.conn.Notify(ctx, "notifications/progress", payload)
+}
 
-	payload, err := io.ReadAll(r.Body)
-	if err != nil {
-		jsonError(w, "cannot read request body", http.StatusInternalServerError)
-		return
-	}
+// streamableStream adapts a request's streamContext to jsonrpc2.Stream, so
+// a Conn can push progress notifications and server-to-client requests down
+// the (possibly not-yet-upgraded) response writer.
+type streamableStream struct {
+	sc *streamContext
+}
 
-	var req JSONRPCRequest
-	if err := json.Unmarshal(payload, &req); err != nil {
-		jsonError(w, "failed to parse body", http.StatusBadRequest)
-		return
+func (st *streamableStream) Write(_ context.Context, data []byte) error {
+	sendEventTo(st.sc, eventProgress, string(data))
+	return nil
+}
+
+func (st *streamableStream) Close() error { return nil }
+
+// streamableHandler dispatches the streamable /mcp endpoint. It tracks every
+// request currently holding an open SSE-upgraded stream so that tool
+// registry changes can be pushed to them out of band.
+//
+// Each POST gets its own throwaway jsonrpc2.Conn (see ServeHTTP), so
+// jsonrpc2.Conn.Call is unusable here: a server-to-client request's reply
+// arrives as a separate POST that builds a brand-new Conn with an empty
+// pending map, and the original Call would block until its ctx expires.
+// Server-initiated requests (sampling/createMessage, roots/list) are only
+// demonstrated on the SSE and WebSocket transports, whose Conn lives for the
+// whole session.
+type streamableHandler struct {
+	registry *mcp.ToolRegistry
+	router   *jsonrpc2.Router
+
+	streamsMu sync.Mutex
+	streams   map[*streamContext]struct{}
+
+	// cancelMu/cancelFns track in-flight "tools/call" requests by JSON-RPC
+	// request ID. The streamable transport hands every POST its own
+	// throwaway jsonrpc2.Conn (see ServeHTTP), so a "notifications/cancelled"
+	// sent as a *separate* POST never shares a Conn with the call it targets
+	// and jsonrpc2.Conn's own per-connection cancellation bookkeeping can't
+	// see it. This handler-scoped map stands in for that across requests.
+	cancelMu  sync.Mutex
+	cancelFns map[string]context.CancelFunc
+}
+
+func newStreamableHandler() *streamableHandler {
+	h := &streamableHandler{
+		streams:   make(map[*streamContext]struct{}),
+		cancelFns: make(map[string]context.CancelFunc),
 	}
+	h.registry = mcp.NewToolRegistry(h.broadcastToolsChanged)
+	tools, handlers := defaultTools()
+	h.registry.Replace(tools, handlers)
+
+	h.router = jsonrpc2.NewRouter()
+	h.router.Handle("initialize", h.handleInitialize)
+	h.router.Handle("tools/list", h.handleToolsList)
+	h.router.Handle("tools/call", h.handleToolCall)
+	return h
+}
 
-	ctx := context.WithValue(r.Context(), ctxKey{}, &streamContext{
-		writer:  w,
-		flusher: w.(http.Flusher),
-		request: &req,
-	})
+func (h *streamableHandler) handleInitialize(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (interface{}, error) {
+	return mcp.InitializeResult{
+		ProtocolVersion: "2025-03-26",
+		ServerInfo: map[string]string{
+			"name":    "Go To-Uppercase Server",
+			"version": "12.0.0",
+		},
+		Capabilities: map[string]interface{}{
+			"tools": map[string]interface{}{"listChanged": true},
+		},
+	}, nil
+}
 
-	resp := dispatch(ctx, req)
-	sc := streamFromContext(ctx)
-	if sc.upgradedToSSE {
-		sendEvent(ctx, eventProgress, toJSONString(resp))
-		closeEventStream(ctx)
+func (h *streamableHandler) handleToolsList(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (interface{}, error) {
+	return mcp.ToolsListResult{Tools: h.registry.List()}, nil
+}
+
+// defaultTools builds the full tool set the server starts with, shared
+// between the constructor's initial Replace and the /admin/tools/restore
+// endpoint's demonstration of restoring it later.
+func defaultTools() ([]mcp.Tool, map[string]mcp.ToolHandler) {
+	tools := []mcp.Tool{toUppercaseTool, toUppercaseSlowlyTool}
+	handlers := map[string]mcp.ToolHandler{
+		toUppercaseTool.Name: mcp.ToolHandlerFunc(func(_ context.Context, args json.RawMessage, _ mcp.ProgressReporter) (mcp.ToolResult, error) {
+			var in struct {
+				Input string `json:"input"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return mcp.ToolResult{}, err
+			}
+			upper, err := performToUppercase(in.Input)
+			if err != nil {
+				return mcp.ToolResult{}, err
+			}
+			return mcp.ToolResult{Content: []mcp.ContentBlock{{Type: "text", Text: upper}}}, nil
+		}),
+		toUppercaseSlowlyTool.Name: mcp.ToolHandlerFunc(func(ctx context.Context, args json.RawMessage, progress mcp.ProgressReporter) (mcp.ToolResult, error) {
+			var in struct {
+				Input string `json:"input"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return mcp.ToolResult{}, err
+			}
+			return streamSlowUppercase(ctx, in.Input, progress)
+		}),
+	}
+	return tools, handlers
+}
+
+// handleAdminRemoveTool unregisters the tool named by the path suffix after
+// pathAdminTools, proving ToolRegistry.Unregister reaches a connected
+// client: every open SSE-upgraded "tools/call" stream gets a live
+// "notifications/tools/list_changed" as a side effect.
+func (h *streamableHandler) handleAdminRemoveTool(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, pathAdminTools)
+	if name == "" {
+		jsonError(w, "tool name must be provided", http.StatusBadRequest)
 		return
 	}
+	h.registry.Unregister(name)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	sendJSON(ctx, resp)
+// handleAdminRestoreTools replaces the live tool set back to the default
+// via ToolRegistry.Replace, the counterpart demo to handleAdminRemoveTool.
+func (h *streamableHandler) handleAdminRestoreTools(w http.ResponseWriter, _ *http.Request) {
+	tools, handlers := defaultTools()
+	h.registry.Replace(tools, handlers)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func dispatch(ctx context.Context, req JSONRPCRequest) *JSONRPCResponse {
-	switch req.Method {
-	case "initialize":
-		result := InitializeResult{
-			ProtocolVersion: "2025-03-26",
-			ServerInfo: map[string]string{
-				"name":    "Go To-Uppercase Server",
-				"version": "12.0.0",
-			},
-			Capabilities: map[string]interface{}{
-				"tools": map[string]interface{}{"listChanged": true},
-			},
+func (h *streamableHandler) handleToolCall(ctx context.Context, _ *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+		Meta      struct {
+			ProgressToken interface{} `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: "invalid params"}
+	}
+
+	var sc *streamContext
+	if params.Name == "to-uppercase-slowly" {
+		sc = streamFromContext(ctx)
+		h.trackStream(sc)
+	}
+	progress := mcp.ProgressReporter(mcp.NoopProgress)
+	if sc != nil {
+		progress = &streamProgressReporter{sc: sc, token: params.Meta.ProgressToken}
+	}
+
+	if req.ID != nil {
+		key := req.ID.String()
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		h.cancelMu.Lock()
+		h.cancelFns[key] = cancel
+		h.cancelMu.Unlock()
+		defer func() {
+			h.cancelMu.Lock()
+			delete(h.cancelFns, key)
+			h.cancelMu.Unlock()
+			cancel()
+		}()
+	}
+
+	result, err := h.registry.Call(ctx, params.Name, params.Arguments, progress)
+	if err != nil {
+		if errors.Is(err, errCancelled) {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeCancelled, Message: "Request cancelled"}
 		}
-		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+		return nil, mcp.ToJSONRPCError(err)
+	}
+	return result, nil
+}
+
+// cancelInFlight cancels the context of the "tools/call" identified by
+// requestID, if this handler is currently holding one. See the cancelFns
+// field comment for why this bypasses jsonrpc2.Conn's own bookkeeping.
+func (h *streamableHandler) cancelInFlight(requestID string) {
+	h.cancelMu.Lock()
+	cancel, ok := h.cancelFns[requestID]
+	h.cancelMu.Unlock()
+	if ok {
+		cancel()
+	}
+}
 
-	case "tools/list":
-		return &JSONRPCResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result:  ToolsListResult{Tools: []Tool{toUppercaseTool, toUppercaseSlowlyTool}},
+// cancelledRequestIDs scans payload - a single JSON-RPC object or a batch
+// array - for "notifications/cancelled" messages and returns the request
+// IDs they target, without committing to parsing the rest of the body.
+func cancelledRequestIDs(payload []byte) []string {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		batch = []json.RawMessage{payload}
+	}
+
+	var ids []string
+	for _, raw := range batch {
+		var msg struct {
+			Method string `json:"method"`
+			Params struct {
+				RequestID jsonrpc2.ID `json:"requestId"`
+			} `json:"params"`
 		}
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.Method != "notifications/cancelled" {
+			continue
+		}
+		ids = append(ids, msg.Params.RequestID.String())
+	}
+	return ids
+}
 
-	case "tools/call":
-		return handleToolCall(ctx, req)
+// broadcastToolsChanged pushes a "notifications/tools/list_changed" message
+// to every streamable client currently holding an open stream.
+func (h *streamableHandler) broadcastToolsChanged() {
+	h.streamsMu.Lock()
+	streams := make([]*streamContext, 0, len(h.streams))
+	for sc := range h.streams {
+		streams = append(streams, sc)
 	}
+	h.streamsMu.Unlock()
 
-	return newErrorResponse(req.ID, -32601, "Method not found")
+	for _, sc := range streams {
+		if err := sc.conn.Notify(context.Background(), "notifications/tools/list_changed", nil); err != nil {
+			log.Printf("notify tools/list_changed failed: %v", err)
+		}
+	}
 }
 
-func handleToolCall(ctx context.Context, req JSONRPCRequest) *JSONRPCResponse {
-	params := req.Params
-	if params == nil {
-		return newErrorResponse(req.ID, -32602, "Missing params")
+func (h *streamableHandler) trackStream(sc *streamContext) {
+	h.streamsMu.Lock()
+	h.streams[sc] = struct{}{}
+	h.streamsMu.Unlock()
+}
+
+func (h *streamableHandler) untrackStream(sc *streamContext) {
+	h.streamsMu.Lock()
+	delete(h.streams, sc)
+	h.streamsMu.Unlock()
+}
+
+func (h *streamableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == pathAdminRestoreTools:
+		h.handleAdminRestoreTools(w, r)
+		return
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, pathAdminTools):
+		h.handleAdminRemoveTool(w, r)
+		return
 	}
 
-	name, _ := params["name"].(string)
-	args, _ := params["arguments"].(map[string]interface{})
-	input, _ := args["input"].(string)
+	if r.Method != http.MethodPost || r.URL.Path != streamablePath {
+		jsonError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	switch name {
-	case "to-uppercase":
-		upper, err := performToUppercase(input)
-		if err != nil {
-			return newErrorResponse(req.ID, -32602, err.Error())
-		}
-		result := ToolResult{
-			Content: []ContentBlock{{Type: "text", Text: upper}},
-			IsError: false,
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		jsonError(w, "cannot read request body", http.StatusInternalServerError)
+		return
+	}
+
+	for _, requestID := range cancelledRequestIDs(payload) {
+		h.cancelInFlight(requestID)
+	}
+
+	sc := &streamContext{
+		writer:  w,
+		flusher: w.(http.Flusher),
+	}
+	sc.conn = jsonrpc2.NewConn(&streamableStream{sc: sc}, h.router.Serve)
+	ctx := context.WithValue(r.Context(), ctxKey{}, sc)
+
+	responses := sc.conn.Deliver(ctx, payload)
+	h.untrackStream(sc)
+
+	if sc.upgradedToSSE {
+		for _, resp := range responses {
+			sendEventTo(sc, eventProgress, toJSONString(resp))
 		}
-		return &JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+		closeEventStream(sc)
+		return
+	}
 
-	case "to-uppercase-slowly":
-		return streamSlowUppercase(ctx, req.ID, input)
-	default:
-		return newErrorResponse(req.ID, -32602, "Unsupported tool name")
+	if len(responses) == 1 {
+		sendJSONTo(sc, responses[0])
+	} else {
+		sendJSONTo(sc, responses)
 	}
 }
 
-func streamSlowUppercase(ctx context.Context, id interface{}, input string) *JSONRPCResponse {
+func streamSlowUppercase(ctx context.Context, input string, progress mcp.ProgressReporter) (mcp.ToolResult, error) {
 	upper, err := performToUppercase(input)
 	if err != nil {
-		return newErrorResponse(id, -32602, err.Error())
+		return mcp.ToolResult{}, err
 	}
 
-	sc := streamFromContext(ctx)
-	progressToken := extractProgressToken(sc.request)
-
 	for i := 1; i <= 10; i++ {
-		payload := map[string]interface{}{
-			"jsonrpc": "2.0",
-			"method":  "notifications/progress",
-			"params": map[string]interface{}{
-				"progress":      i,
-				"total":         10,
-				"progressToken": progressToken,
-				"message":       fmt.Sprintf("Server progress %d%%", i*10),
-			},
+		if err := progress.Report(ctx, i, 10, fmt.Sprintf("Server progress %d%%", i*10)); err != nil {
+			log.Printf("send progress notification failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return mcp.ToolResult{}, errCancelled
+		case <-time.After(300 * time.Millisecond):
 		}
-		sendEvent(ctx, eventProgress, toJSONString(payload))
-		time.Sleep(300 * time.Millisecond)
 	}
 
-	result := ToolResult{
-		Content: []ContentBlock{{Type: "text", Text: upper}},
+	return mcp.ToolResult{
+		Content: []mcp.ContentBlock{{Type: "text", Text: upper}},
 		IsError: false,
-	}
-	return &JSONRPCResponse{JSONRPC: "2.0", ID: id, Result: result}
+	}, nil
 }
 
-func sendJSON(ctx context.Context, data interface{}) {
-	sc := streamFromContext(ctx)
+func sendJSONTo(sc *streamContext, data interface{}) {
 	sc.writer.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(sc.writer).Encode(data)
 }
 
-func sendEvent(ctx context.Context, name, data string) {
-	sc := streamFromContext(ctx)
-
+func sendEventTo(sc *streamContext, name, data string) {
 	if !sc.upgradedToSSE {
 		sc.upgradedToSSE = true
 		sc.writer.Header().Set("Content-Type", "text/event-stream")
@@ -275,8 +435,7 @@ func sendEvent(ctx context.Context, name, data string) {
 	sc.flusher.Flush()
 }
 
-func closeEventStream(ctx context.Context) {
-	sc := streamFromContext(ctx)
+func closeEventStream(sc *streamContext) {
 	if sc == nil || !sc.upgradedToSSE {
 		return
 	}
@@ -293,17 +452,6 @@ func closeEventStream(ctx context.Context) {
 	}
 }
 
-func newErrorResponse(id interface{}, code int, message string) *JSONRPCResponse {
-	return &JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id,
-		Error: &JSONRPCError{
-			Code:    code,
-			Message: message,
-		},
-	}
-}
-
 func performToUppercase(input string) (string, error) {
 	input = strings.TrimSpace(input)
 	if input == "" {
@@ -312,17 +460,6 @@ func performToUppercase(input string) (string, error) {
 	return strings.ToUpper(input), nil
 }
 
-func extractProgressToken(req *JSONRPCRequest) interface{} {
-	if req == nil || req.Params == nil {
-		return nil
-	}
-	meta, _ := req.Params["_meta"].(map[string]interface{})
-	if meta == nil {
-		return nil
-	}
-	return meta["progressToken"]
-}
-
 func streamFromContext(ctx context.Context) *streamContext {
 	sc, _ := ctx.Value(ctxKey{}).(*streamContext)
 	return sc
@@ -345,7 +482,14 @@ func jsonError(w http.ResponseWriter, message string, code int) {
 }
 
 func main() {
-	http.Handle(streamablePath, &streamableHandler{})
+	verifier, err := auth.VerifierFromEnv()
+	if err != nil {
+		log.Fatalf("configure auth: %v", err)
+	}
+
+	h := auth.NewAuthenticator(verifier, newStreamableHandler())
+	http.Handle(streamablePath, h)
+	http.Handle(pathAdminTools, h)
 	log.Println("Streamable MCP server listening on http://localhost:9090")
 	log.Fatal(http.ListenAndServe(":9090", nil))
 }
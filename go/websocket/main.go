@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/CCpro10/mcp_examples/go/auth"
+	"github.com/CCpro10/mcp_examples/go/jsonrpc2"
+	"github.com/CCpro10/mcp_examples/go/mcp"
+)
+
+// errCancelled is returned by a tool handler when its ctx is done because
+// the client sent "notifications/cancelled" for this request.
+var errCancelled = errors.New("request cancelled")
+
+const (
+	pathWS = "/ws"
+
+	// subprotocol is the MCP-specific WebSocket subprotocol negotiated
+	// during the upgrade handshake.
+	subprotocol = "mcp.jsonrpc.v1"
+)
+
+var (
+	toUppercaseTool = mcp.Tool{
+		Name:        "to-uppercase",
+		Description: "Converts the input string to uppercase.",
+		InputSchema: mcp.InputSchema{
+			Type: "object",
+			Properties: map[string]mcp.PropertyDef{
+				"input": {
+					Type:        "string",
+					Description: "The string to be converted to uppercase.",
+				},
+			},
+			Required: []string{"input"},
+		},
+	}
+
+	toUppercaseSlowlyTool = mcp.Tool{
+		Name:        "to-uppercase-slowly",
+		Description: "Converts the input string to uppercase. (simulates slow processing)",
+		InputSchema: mcp.InputSchema{
+			Type: "object",
+			Properties: map[string]mcp.PropertyDef{
+				"input": {
+					Type:        "string",
+					Description: "The string to be converted to uppercase.",
+				},
+			},
+			Required: []string{"input"},
+		},
+	}
+)
+
+// wsProgressReporter reports "notifications/progress" down the socket's
+// jsonrpc2.Conn, carrying the client-supplied progressToken so a
+// ToolHandler never has to know it is running over WebSocket.
+type wsProgressReporter struct {
+	conn  *jsonrpc2.Conn
+	token interface{}
+}
+
+func (p *wsProgressReporter) Report(ctx context.Context, progress, total int, message string) error {
+	payload := map[string]interface{}{
+		"progress":      progress,
+		"total":         total,
+		"progressToken": p.token,
+		"message":       message,
+	}
+	return p.conn.Notify(ctx, "notifications/progress", payload)
+}
+
+// wsStream adapts a gorilla/websocket connection to jsonrpc2.Stream. Writes
+// are serialized since websocket.Conn forbids concurrent writers.
+type wsStream struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (s *wsStream) Write(_ context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *wsStream) Close() error {
+	return s.conn.Close()
+}
+
+var upgrader = websocket.Upgrader{
+	Subprotocols:    []string{subprotocol},
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsHandler upgrades /ws requests and multiplexes the same JSON-RPC dispatch
+// used by the SSE and streamable transports over a persistent, bidirectional
+// socket.
+type wsHandler struct {
+	registry *mcp.ToolRegistry
+	router   *jsonrpc2.Router
+
+	connsMu sync.Mutex
+	conns   map[*jsonrpc2.Conn]struct{}
+}
+
+func newWSHandler() *wsHandler {
+	h := &wsHandler{conns: make(map[*jsonrpc2.Conn]struct{})}
+	h.registry = mcp.NewToolRegistry(h.broadcastToolsChanged)
+	h.registry.Register(toUppercaseTool, mcp.ToolHandlerFunc(func(_ context.Context, args json.RawMessage, _ mcp.ProgressReporter) (mcp.ToolResult, error) {
+		var in struct {
+			Input string `json:"input"`
+		}
+		if err := json.Unmarshal(args, &in); err != nil {
+			return mcp.ToolResult{}, err
+		}
+		upper, err := performToUppercase(in.Input)
+		if err != nil {
+			return mcp.ToolResult{}, err
+		}
+		return mcp.ToolResult{Content: []mcp.ContentBlock{{Type: "text", Text: upper}}}, nil
+	}))
+	h.registry.Register(toUppercaseSlowlyTool, mcp.ToolHandlerFunc(func(ctx context.Context, args json.RawMessage, progress mcp.ProgressReporter) (mcp.ToolResult, error) {
+		var in struct {
+			Input string `json:"input"`
+		}
+		if err := json.Unmarshal(args, &in); err != nil {
+			return mcp.ToolResult{}, err
+		}
+		return streamSlowUppercase(ctx, in.Input, progress)
+	}))
+
+	h.router = jsonrpc2.NewRouter()
+	h.router.Handle("initialize", h.handleInitialize)
+	h.router.Handle("tools/list", h.handleToolsList)
+	h.router.Handle("tools/call", h.handleToolCall)
+	return h
+}
+
+func (h *wsHandler) handleInitialize(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (interface{}, error) {
+	return mcp.InitializeResult{
+		ProtocolVersion: "2025-03-26",
+		ServerInfo: map[string]string{
+			"name":    "Go To-Uppercase Server",
+			"version": "12.0.0",
+		},
+		Capabilities: map[string]interface{}{
+			"tools": map[string]interface{}{"listChanged": true},
+		},
+	}, nil
+}
+
+func (h *wsHandler) handleToolsList(_ context.Context, _ *jsonrpc2.Conn, _ *jsonrpc2.Request) (interface{}, error) {
+	return mcp.ToolsListResult{Tools: h.registry.List()}, nil
+}
+
+func (h *wsHandler) handleToolCall(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) (interface{}, error) {
+	var params struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+		Meta      struct {
+			ProgressToken interface{} `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeInvalidParams, Message: "invalid params"}
+	}
+
+	progress := &wsProgressReporter{conn: conn, token: params.Meta.ProgressToken}
+	result, err := h.registry.Call(ctx, params.Name, params.Arguments, progress)
+	if err != nil {
+		if errors.Is(err, errCancelled) {
+			return nil, &jsonrpc2.Error{Code: jsonrpc2.CodeCancelled, Message: "Request cancelled"}
+		}
+		return nil, mcp.ToJSONRPCError(err)
+	}
+	return result, nil
+}
+
+// broadcastToolsChanged pushes a "notifications/tools/list_changed" message
+// to every open socket.
+func (h *wsHandler) broadcastToolsChanged() {
+	h.connsMu.Lock()
+	conns := make([]*jsonrpc2.Conn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.connsMu.Unlock()
+
+	for _, c := range conns {
+		if err := c.Notify(context.Background(), "notifications/tools/list_changed", nil); err != nil {
+			log.Printf("notify tools/list_changed failed: %v", err)
+		}
+	}
+}
+
+func (h *wsHandler) trackConn(conn *jsonrpc2.Conn) {
+	h.connsMu.Lock()
+	h.conns[conn] = struct{}{}
+	h.connsMu.Unlock()
+}
+
+func (h *wsHandler) untrackConn(conn *jsonrpc2.Conn) {
+	h.connsMu.Lock()
+	delete(h.conns, conn)
+	h.connsMu.Unlock()
+}
+
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != pathWS {
+		jsonError(w, "Path not found", http.StatusNotFound)
+		return
+	}
+
+	wsConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket upgrade failed: %v", err)
+		return
+	}
+	defer wsConn.Close()
+
+	stream := &wsStream{conn: wsConn}
+	conn := jsonrpc2.NewConn(stream, h.router.Serve)
+	h.trackConn(conn)
+	defer h.untrackConn(conn)
+
+	ctx := r.Context()
+	for {
+		msgType, data, err := wsConn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if msgType != websocket.TextMessage && msgType != websocket.BinaryMessage {
+			continue
+		}
+
+		go h.deliver(ctx, conn, stream, data)
+	}
+}
+
+func (h *wsHandler) deliver(ctx context.Context, conn *jsonrpc2.Conn, stream *wsStream, data []byte) {
+	for _, resp := range conn.Deliver(ctx, data) {
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			log.Printf("marshal response failed: %v", err)
+			continue
+		}
+		if err := stream.Write(ctx, payload); err != nil {
+			log.Printf("write response failed: %v", err)
+			return
+		}
+	}
+}
+
+func streamSlowUppercase(ctx context.Context, input string, progress mcp.ProgressReporter) (mcp.ToolResult, error) {
+	upper, err := performToUppercase(input)
+	if err != nil {
+		return mcp.ToolResult{}, err
+	}
+
+	for i := 1; i <= 10; i++ {
+		if err := progress.Report(ctx, i, 10, fmt.Sprintf("Server progress %d%%", i*10)); err != nil {
+			log.Printf("send progress notification failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return mcp.ToolResult{}, errCancelled
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	return mcp.ToolResult{
+		Content: []mcp.ContentBlock{{Type: "text", Text: upper}},
+		IsError: false,
+	}, nil
+}
+
+func performToUppercase(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", fmt.Errorf("input string cannot be empty")
+	}
+	return strings.ToUpper(input), nil
+}
+
+func jsonError(w http.ResponseWriter, message string, code int) {
+	log.Printf("sending error response: %s", message)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func main() {
+	verifier, err := auth.VerifierFromEnv()
+	if err != nil {
+		log.Fatalf("configure auth: %v", err)
+	}
+
+	http.Handle(pathWS, auth.NewAuthenticator(verifier, newWSHandler()))
+	log.Println("WebSocket MCP server listening on http://localhost:7070")
+	log.Fatal(http.ListenAndServe(":7070", nil))
+}